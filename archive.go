@@ -0,0 +1,332 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	unpackedSentinel = ".unpacked-success"
+	goRootPrefix     = "go/"
+	binDirPrefix     = "bin/"
+	toolDirPrefix    = "pkg/tool/"
+)
+
+// archiveKind returns the extraction format for a downloaded file, using the
+// upstream Kind field to exclude source tarballs and installers (which share
+// the same "go/"-rooted layout but aren't a platform archive) before falling
+// back to the filename suffix to pick the format.
+func archiveKind(file File) (string, bool) {
+	switch file.Kind {
+	case "source", "installer":
+		return "", false
+	}
+
+	switch {
+	case strings.HasSuffix(file.Filename, ".tar.gz"):
+		return "tar.gz", true
+	case strings.HasSuffix(file.Filename, ".zip"):
+		return "zip", true
+	default:
+		return "", false
+	}
+}
+
+// installDir is the per-version GOROOT-style layout, e.g. "<releaseName>/go".
+func installDir(releaseName string) string { return path.Join(releaseName, "go") }
+
+func sentinelPath(dir string) string { return path.Join(dir, unpackedSentinel) }
+
+// alreadyUnpacked reports whether dir has a sentinel recording the given sha.
+func alreadyUnpacked(dir string, sha Hash) bool {
+	recorded, err := ioutil.ReadFile(sentinelPath(dir))
+	if err != nil {
+		return false
+	}
+
+	return strings.TrimSpace(string(recorded)) == string(sha)
+}
+
+func writeSentinel(dir string, sha Hash) error {
+	return writeHash(sentinelPath(dir), string(sha))
+}
+
+// unpackRelease extracts target (the downloaded archive for file) into the
+// per-version GOROOT layout under releaseName, skipping the work if the
+// sentinel already matches file.SHA256Sum.
+func unpackRelease(releaseName, target string, file File) bool {
+	dir := installDir(releaseName)
+
+	if alreadyUnpacked(dir, file.SHA256Sum) {
+		fmt.Fprintf(statusOut, "  already unpacked %q\n", dir)
+
+		return true
+	}
+
+	kind, ok := archiveKind(file)
+	if !ok {
+		fmt.Fprintf(errOut, "  could not determine archive kind for %q\n", target)
+
+		return false
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		fmt.Fprintf(errOut, "  could not clear %q: %v\n", dir, err)
+
+		return false
+	}
+
+	if err := os.MkdirAll(dir, dirPerms); err != nil {
+		fmt.Fprintf(errOut, "  could not create %q: %v\n", dir, err)
+
+		return false
+	}
+
+	var err error
+
+	switch kind {
+	case "tar.gz":
+		err = extractTarGz(target, dir)
+	case "zip":
+		err = extractZip(target, dir)
+	}
+
+	if err != nil {
+		fmt.Fprintf(errOut, "  could not unpack %q: %v\n", target, err)
+
+		return false
+	}
+
+	if err = writeSentinel(dir, file.SHA256Sum); err != nil {
+		return false
+	}
+
+	fmt.Fprintf(statusOut, "  unpacked %q\n", dir)
+
+	return true
+}
+
+// stripGoRoot removes the leading "go/" directory that upstream archives are
+// rooted at, since destDir already represents that directory.
+func stripGoRoot(name string) (string, bool) {
+	name = filepath.ToSlash(name)
+	if name == "go" {
+		return "", true
+	}
+
+	if strings.HasPrefix(name, goRootPrefix) {
+		return strings.TrimPrefix(name, goRootPrefix), true
+	}
+
+	return "", false
+}
+
+// safeJoin joins destDir and a slash-separated path relative to it, rejecting
+// any result that would resolve outside destDir. A crafted archive entry (or
+// symlink target) using ".." segments would otherwise let extraction write
+// or link outside the extraction directory.
+func safeJoin(destDir, rel string) (string, bool) {
+	target := filepath.Join(destDir, filepath.FromSlash(rel))
+
+	if target != destDir && !strings.HasPrefix(target, destDir+string(filepath.Separator)) {
+		return "", false
+	}
+
+	return target, true
+}
+
+// execMode returns the mode to apply for a regular file, forcing the
+// executable bits on for binaries under bin/ and pkg/tool/.
+func execMode(name string, mode os.FileMode) os.FileMode {
+	if strings.HasPrefix(name, binDirPrefix) || strings.HasPrefix(name, toolDirPrefix) {
+		return mode | 0o111
+	}
+
+	return mode
+}
+
+func extractTarGz(source, destDir string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err // nolint: wrapcheck
+	}
+	defer in.Close()
+
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return err // nolint: wrapcheck
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err // nolint: wrapcheck
+		}
+
+		rel, ok := stripGoRoot(hdr.Name)
+		if !ok || rel == "" {
+			continue
+		}
+
+		target, ok := safeJoin(destDir, rel)
+		if !ok {
+			return fmt.Errorf("refusing to extract %q outside %q", hdr.Name, destDir)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err = os.MkdirAll(target, dirPerms); err != nil {
+				return err // nolint: wrapcheck
+			}
+		case tar.TypeReg:
+			if err = extractTarFile(tr, target, rel, hdr.FileInfo().Mode()); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if filepath.IsAbs(filepath.FromSlash(hdr.Linkname)) {
+				return fmt.Errorf("refusing to extract %q: absolute symlink target %q", hdr.Name, hdr.Linkname)
+			}
+
+			if _, ok = safeJoin(destDir, path.Join(path.Dir(rel), hdr.Linkname)); !ok {
+				return fmt.Errorf("refusing to extract %q: symlink target %q escapes %q", hdr.Name, hdr.Linkname, destDir)
+			}
+
+			if err = os.MkdirAll(filepath.Dir(target), dirPerms); err != nil {
+				return err // nolint: wrapcheck
+			}
+
+			_ = os.Remove(target)
+
+			if err = os.Symlink(hdr.Linkname, target); err != nil {
+				return err // nolint: wrapcheck
+			}
+		}
+	}
+}
+
+func extractTarFile(r io.Reader, target, rel string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), dirPerms); err != nil {
+		return err // nolint: wrapcheck
+	}
+
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, execMode(rel, mode))
+	if err != nil {
+		return err // nolint: wrapcheck
+	}
+	defer out.Close()
+
+	if _, err = io.CopyBuffer(out, r, staticBuffer); err != nil {
+		return err // nolint: wrapcheck
+	}
+
+	return nil
+}
+
+func extractZip(source, destDir string) error {
+	zr, err := zip.OpenReader(source)
+	if err != nil {
+		return err // nolint: wrapcheck
+	}
+	defer zr.Close()
+
+	for _, zf := range zr.File {
+		rel, ok := stripGoRoot(zf.Name)
+		if !ok || rel == "" {
+			continue
+		}
+
+		target, ok := safeJoin(destDir, rel)
+		if !ok {
+			return fmt.Errorf("refusing to extract %q outside %q", zf.Name, destDir)
+		}
+
+		if zf.FileInfo().IsDir() {
+			if err = os.MkdirAll(target, dirPerms); err != nil {
+				return err // nolint: wrapcheck
+			}
+
+			continue
+		}
+
+		if err = extractZipFile(zf, target, rel); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func extractZipFile(zf *zip.File, target, rel string) error {
+	if err := os.MkdirAll(filepath.Dir(target), dirPerms); err != nil {
+		return err // nolint: wrapcheck
+	}
+
+	in, err := zf.Open()
+	if err != nil {
+		return err // nolint: wrapcheck
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, execMode(rel, zf.Mode()))
+	if err != nil {
+		return err // nolint: wrapcheck
+	}
+	defer out.Close()
+
+	if _, err = io.CopyBuffer(out, in, staticBuffer); err != nil {
+		return err // nolint: wrapcheck
+	}
+
+	return nil
+}
+
+// linkInstallRoot creates (or replaces) <installRoot>/go<version> pointing at
+// the extracted GOROOT for releaseName, where version is releaseName without
+// its leading "go" (so "go1.22.3" links as "go1.22.3"). On Windows this requires either
+// Developer Mode or administrator privileges to create a directory symlink.
+func linkInstallRoot(installRoot, releaseName string) bool {
+	if installRoot == "" {
+		return true
+	}
+
+	if err := os.MkdirAll(installRoot, dirPerms); err != nil {
+		fmt.Fprintf(errOut, "  could not create %q: %v\n", installRoot, err)
+
+		return false
+	}
+
+	link := filepath.Join(installRoot, "go"+strings.TrimPrefix(releaseName, "go"))
+	target, err := filepath.Abs(installDir(releaseName))
+	if err != nil {
+		fmt.Fprintf(errOut, "  could not resolve %q: %v\n", installDir(releaseName), err)
+
+		return false
+	}
+
+	_ = os.Remove(link)
+
+	if err = os.Symlink(target, link); err != nil {
+		fmt.Fprintf(errOut, "  could not link %q -> %q: %v\n", link, target, err)
+
+		return false
+	}
+
+	fmt.Fprintf(statusOut, "  linked %q -> %q\n", link, target)
+
+	return true
+}
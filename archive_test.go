@@ -0,0 +1,162 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStripGoRoot(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		want string
+		ok   bool
+	}{
+		{"bare go dir", "go", "", true},
+		{"nested file", "go/bin/go", "bin/go", true},
+		{"not rooted", "bin/go", "", false},
+		{"traversal survives stripping", "go/../../../etc/cron.d/evil", "../../../etc/cron.d/evil", true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, ok := stripGoRoot(c.in)
+			if ok != c.ok || got != c.want {
+				t.Errorf("stripGoRoot(%q) = (%q, %v), want (%q, %v)", c.in, got, ok, c.want, c.ok)
+			}
+		})
+	}
+}
+
+func TestSafeJoin(t *testing.T) {
+	destDir := t.TempDir()
+
+	cases := []struct {
+		name string
+		rel  string
+		ok   bool
+	}{
+		{"plain file", "bin/go", true},
+		{"nested dir", "pkg/tool/linux_amd64/compile", true},
+		{"traversal via stripped prefix", "../../../etc/cron.d/evil", false},
+		{"traversal buried in a subpath", "bin/../../evil", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			target, ok := safeJoin(destDir, c.rel)
+			if ok != c.ok {
+				t.Errorf("safeJoin(%q, %q) = (%q, %v), want ok=%v", destDir, c.rel, target, ok, c.ok)
+			}
+		})
+	}
+}
+
+type tarEntry struct {
+	name     string
+	typ      byte
+	body     []byte
+	linkname string
+}
+
+func buildTarGz(t *testing.T, path string, entries []tarEntry) {
+	t.Helper()
+
+	out, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typ,
+			Mode:     0o644,
+			Size:     int64(len(e.body)),
+			Linkname: e.linkname,
+		}
+
+		if err = tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(e.body) > 0 {
+			if _, err = tw.Write(e.body); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if err = tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractTarGzRejectsPathTraversal(t *testing.T) {
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "evil.tar.gz")
+
+	buildTarGz(t, archivePath, []tarEntry{
+		{name: "go/../../../etc/cron.d/evil", typ: tar.TypeReg, body: []byte("evil")},
+	})
+
+	destDir := filepath.Join(dir, "dest")
+	if err := os.MkdirAll(destDir, dirPerms); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := extractTarGz(archivePath, destDir); err == nil {
+		t.Fatal("extractTarGz: expected an error for a path-traversal entry, got nil")
+	}
+}
+
+func TestExtractTarGzRejectsEscapingSymlink(t *testing.T) {
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "evil.tar.gz")
+
+	buildTarGz(t, archivePath, []tarEntry{
+		{name: "go/bin/go", typ: tar.TypeSymlink, linkname: "../../../../etc/passwd"},
+	})
+
+	destDir := filepath.Join(dir, "dest")
+	if err := os.MkdirAll(destDir, dirPerms); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := extractTarGz(archivePath, destDir); err == nil {
+		t.Fatal("extractTarGz: expected an error for an escaping symlink target, got nil")
+	}
+}
+
+func TestExtractTarGzAcceptsWellFormedArchive(t *testing.T) {
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "good.tar.gz")
+
+	buildTarGz(t, archivePath, []tarEntry{
+		{name: "go/bin/go", typ: tar.TypeReg, body: []byte("#!/bin/sh\n")},
+	})
+
+	destDir := filepath.Join(dir, "dest")
+	if err := os.MkdirAll(destDir, dirPerms); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := extractTarGz(archivePath, destDir); err != nil {
+		t.Fatalf("extractTarGz: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(destDir, "bin", "go")); err != nil {
+		t.Fatalf("expected bin/go to be extracted: %v", err)
+	}
+}
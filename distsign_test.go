@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// fakeArtifactSource is a minimal Source backed by an in-memory map, keyed
+// the same way httpsSource lays out distsign artifacts: "<name>" for
+// release-independent artifacts, "<releaseName>/<name>" otherwise.
+type fakeArtifactSource struct {
+	artifacts map[string][]byte
+}
+
+func (f *fakeArtifactSource) Releases() (Releases, error) { return nil, nil }
+
+func (f *fakeArtifactSource) Open(File, int64) (io.ReadCloser, bool, error) {
+	return nil, false, os.ErrNotExist
+}
+
+func (f *fakeArtifactSource) OpenArtifact(releaseName, name string) (io.ReadCloser, error) {
+	key := name
+	if releaseName != "" {
+		key = releaseName + "/" + name
+	}
+
+	b, ok := f.artifacts[key]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+func TestActiveSigningKey(t *testing.T) {
+	expiredPub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	activePub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bundle := keyBundle{SigningKeys: []signingKey{
+		{PublicKey: hex.EncodeToString(expiredPub), Expires: time.Now().Add(-time.Hour)},
+		{PublicKey: hex.EncodeToString(activePub), Expires: time.Now().Add(time.Hour)},
+	}}
+
+	got, err := activeSigningKey(bundle, time.Now())
+	if err != nil {
+		t.Fatalf("activeSigningKey: %v", err)
+	}
+
+	if !bytes.Equal(got, activePub) {
+		t.Fatalf("activeSigningKey returned the expired key, want the active one")
+	}
+
+	if _, err = activeSigningKey(keyBundle{SigningKeys: []signingKey{
+		{PublicKey: hex.EncodeToString(expiredPub), Expires: time.Now().Add(-time.Hour)},
+	}}, time.Now()); err == nil {
+		t.Fatal("activeSigningKey: expected an error when every key has expired")
+	}
+}
+
+func withTempWorkdir(t *testing.T) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+}
+
+func TestKeyBundleRefetchesOnCacheMismatch(t *testing.T) {
+	withTempWorkdir(t)
+
+	rootPub, rootPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signingPub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := json.Marshal(keyBundle{SigningKeys: []signingKey{
+		{PublicKey: hex.EncodeToString(signingPub), Expires: time.Now().Add(time.Hour)},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig := ed25519.Sign(rootPriv, body)
+
+	// Seed a cache whose body and signature don't verify together, as the
+	// pre-fix code could leave behind once the upstream bundle rotated.
+	stale, err := json.Marshal(cachedKeys{Body: []byte("stale-body"), Sig: sig})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ioutil.WriteFile(keysCacheFile, stale, filePerms); err != nil {
+		t.Fatal(err)
+	}
+
+	src := &fakeArtifactSource{artifacts: map[string][]byte{
+		signingKeysFilename:             body,
+		signingKeysFilename + sigSuffix: []byte(hex.EncodeToString(sig)),
+	}}
+
+	v := &verifier{roots: []ed25519.PublicKey{rootPub}, src: src}
+
+	bundle, err := v.keyBundle()
+	if err != nil {
+		t.Fatalf("keyBundle: %v", err)
+	}
+
+	if len(bundle.SigningKeys) != 1 || bundle.SigningKeys[0].PublicKey != hex.EncodeToString(signingPub) {
+		t.Fatalf("keyBundle returned %+v, want the freshly fetched bundle", bundle)
+	}
+
+	cached, sig2, ok := v.loadCachedKeys()
+	if !ok || !bytes.Equal(cached, body) || !bytes.Equal(sig2, sig) {
+		t.Fatalf("keyBundle did not persist the refetched (body, sig) pair to the cache")
+	}
+}
+
+func TestKeyBundleRejectsUntrustedRoot(t *testing.T) {
+	withTempWorkdir(t)
+
+	_, otherPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trustedPub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := json.Marshal(keyBundle{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig := ed25519.Sign(otherPriv, body)
+
+	src := &fakeArtifactSource{artifacts: map[string][]byte{
+		signingKeysFilename:             body,
+		signingKeysFilename + sigSuffix: []byte(hex.EncodeToString(sig)),
+	}}
+
+	v := &verifier{roots: []ed25519.PublicKey{trustedPub}, src: src}
+
+	if _, err = v.keyBundle(); err == nil {
+		t.Fatal("keyBundle: expected an error for a bundle signed by an untrusted root")
+	}
+}
+
+func TestCheckManifestDetectsMismatch(t *testing.T) {
+	withTempWorkdir(t)
+
+	rootPub, rootPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signingPub, signingPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keysBody, err := json.Marshal(keyBundle{SigningKeys: []signingKey{
+		{PublicKey: hex.EncodeToString(signingPub), Expires: time.Now().Add(time.Hour)},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keysSig := ed25519.Sign(rootPriv, keysBody)
+
+	manifestBody, err := json.Marshal(manifest{Files: []manifestFile{
+		{Filename: "go1.22.3.linux-amd64.tar.gz", Size: 100, SHA256: "aa"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifestSig := ed25519.Sign(signingPriv, manifestBody)
+
+	src := &fakeArtifactSource{artifacts: map[string][]byte{
+		signingKeysFilename:                        keysBody,
+		signingKeysFilename + sigSuffix:             []byte(hex.EncodeToString(keysSig)),
+		"go1.22.3/" + manifestFilename:              manifestBody,
+		"go1.22.3/" + manifestFilename + sigSuffix:  []byte(hex.EncodeToString(manifestSig)),
+	}}
+
+	v := &verifier{roots: []ed25519.PublicKey{rootPub}, src: src}
+
+	file := File{Filename: "go1.22.3.linux-amd64.tar.gz", Size: 100, SHA256Sum: Hash("aa")}
+	if err = v.checkManifest("go1.22.3", file, time.Now()); err != nil {
+		t.Fatalf("checkManifest: expected a matching file to pass, got %v", err)
+	}
+
+	mismatched := File{Filename: "go1.22.3.linux-amd64.tar.gz", Size: 999, SHA256Sum: Hash("aa")}
+	if err = v.checkManifest("go1.22.3", mismatched, time.Now()); err == nil {
+		t.Fatal("checkManifest: expected a size mismatch against the signed manifest to fail")
+	}
+}
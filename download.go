@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const partSuffix = ".part"
+
+// job is a unit of download work: one file belonging to one release.
+type job struct {
+	release string
+	file    File
+}
+
+// runDownloads drives a worker pool of size concurrency over jobs, each
+// worker resuming partial downloads and verifying the SHA-256 in-stream. A
+// non-nil verifier additionally authenticates each archive's signature
+// before it is renamed into place.
+func runDownloads(src Source, v *verifier, jobs []job, concurrency int) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	queue := make(chan job)
+
+	var wg sync.WaitGroup
+
+	for ix := 0; ix < concurrency; ix++ {
+		wg.Add(1)
+
+		go func(worker int) {
+			defer wg.Done()
+
+			for j := range queue {
+				downloadFile(worker, src, v, j.release, j.file)
+			}
+		}(ix)
+	}
+
+	for _, j := range jobs {
+		queue <- j
+	}
+
+	close(queue)
+	wg.Wait()
+}
+
+func partTarget(target string) string { return target + partSuffix }
+
+// resumeState inspects an existing .part file and returns the offset to
+// resume from along with a hasher pre-seeded with its current contents.
+func resumeState(part string) (int64, hash.Hash, error) {
+	hasher := sha256.New()
+
+	in, err := os.Open(part)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, hasher, nil
+		}
+
+		return 0, nil, err // nolint: wrapcheck
+	}
+	defer in.Close()
+
+	n, err := io.CopyBuffer(hasher, in, staticBuffer)
+	if err != nil {
+		return 0, nil, err // nolint: wrapcheck
+	}
+
+	return n, hasher, nil
+}
+
+// downloadFile fetches download into its per-release directory, resuming
+// from an existing .part file when the source supports it and verifying its
+// SHA-256 as it streams rather than rereading it afterwards.
+func downloadFile(worker int, src Source, v *verifier, releaseName string, download File) {
+	target := dlTarget(releaseName, download)
+	part := partTarget(target)
+
+	offset, hasher, err := resumeState(part)
+	if err != nil {
+		fmt.Fprintf(errOut, "  [%d] could not resume %q: %v\n", worker, part, err)
+
+		return
+	}
+
+	fmt.Fprintf(errOut, "  [%d] getting %s/%s\n", worker, releaseName, download.Filename)
+
+	body, resumed, err := src.Open(download, offset)
+	if err != nil {
+		fmt.Fprintf(errOut, "  [%d] could not fetch %s/%s: %v\n", worker, releaseName, download.Filename, err)
+
+		return
+	}
+	defer body.Close()
+
+	if !resumed {
+		offset = 0
+		hasher = sha256.New()
+	}
+
+	if !writeDownloadBody(worker, part, offset, body, hasher, releaseName, download) {
+		return
+	}
+
+	digest := hasher.Sum(nil)
+	if !download.SHA256Sum.Equal(digest) {
+		fmt.Fprintf(errOut, "  [%d] sha mismatch for %q; expected %s\n", worker, target, download.SHA256Sum)
+
+		return
+	}
+
+	if v != nil {
+		if err = v.checkFileSignature(releaseName, download, digest, time.Now()); err != nil {
+			fmt.Fprintf(errOut, "  [%d] signature check failed for %q: %v\n", worker, target, err)
+
+			return
+		}
+	}
+
+	if err = os.Rename(part, target); err != nil {
+		fmt.Fprintf(errOut, "  [%d] could not rename %q: %v\n", worker, part, err)
+
+		return
+	}
+
+	if err = writeHash(dlSHA(target), string(download.SHA256Sum)); err != nil {
+		return
+	}
+
+	fmt.Fprintf(statusOut, "  [%d] downloaded %q\n", worker, target)
+}
+
+func writeDownloadBody(worker int, part string, offset int64, body io.Reader, hasher hash.Hash, releaseName string, download File) bool {
+	out, err := os.OpenFile(part, os.O_CREATE|os.O_WRONLY, filePerms)
+	if err != nil {
+		fmt.Fprintf(errOut, "  [%d] could not open %q: %v\n", worker, part, err)
+
+		return false
+	}
+	defer out.Close()
+
+	if _, err = out.Seek(offset, io.SeekStart); err != nil {
+		fmt.Fprintf(errOut, "  [%d] could not seek %q: %v\n", worker, part, err)
+
+		return false
+	}
+
+	// A stale .part left over from an earlier, longer attempt that isn't
+	// being resumed would otherwise leave its trailing bytes past whatever
+	// this run writes.
+	if err = out.Truncate(offset); err != nil {
+		fmt.Fprintf(errOut, "  [%d] could not truncate %q: %v\n", worker, part, err)
+
+		return false
+	}
+
+	p := newProgress(worker, releaseName, download, offset)
+	mw := io.MultiWriter(out, hasher, p)
+
+	if _, err = io.CopyBuffer(mw, body, make([]byte, bufferSize)); err != nil {
+		fmt.Fprintf(errOut, "  [%d] could not download %q: %v\n", worker, part, err)
+
+		return false
+	}
+
+	p.done()
+
+	return true
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const lockFilename = "downloader.lock"
+
+// lockEntry pins one (version, os, arch) download to a specific SHA-256, the
+// way a grabit resource entry pins a fetched artifact.
+type lockEntry struct {
+	Version  string   `json:"version"`
+	Filename string   `json:"filename"`
+	OS       string   `json:"os"`
+	Arch     string   `json:"arch"`
+	SHA256   string   `json:"sha256"`
+	Size     uint64   `json:"size"`
+	Tags     []string `json:"tags,omitempty"`
+}
+
+func (e lockEntry) hasTag(tag string) bool {
+	for _, t := range e.Tags {
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (e lockEntry) file() File {
+	return File{
+		Filename:     e.Filename,
+		OS:           e.OS,
+		Architecture: e.Arch,
+		Version:      e.Version,
+		SHA256Sum:    Hash(e.SHA256),
+		Size:         e.Size,
+	}
+}
+
+// lockFile is the parsed contents of downloader.lock.
+type lockFile struct {
+	Entries []lockEntry `json:"entries"`
+}
+
+func lockFileExists() bool {
+	_, err := os.Stat(lockFilename)
+
+	return err == nil
+}
+
+func loadLockFile() (*lockFile, error) {
+	raw, err := ioutil.ReadFile(lockFilename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &lockFile{}, nil
+		}
+
+		return nil, err // nolint: wrapcheck
+	}
+
+	var l lockFile
+	if err = json.Unmarshal(raw, &l); err != nil {
+		return nil, fmt.Errorf("malformed %s: %w", lockFilename, err)
+	}
+
+	return &l, nil
+}
+
+func (l *lockFile) save() error {
+	raw, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err // nolint: wrapcheck
+	}
+
+	return ioutil.WriteFile(lockFilename, raw, filePerms) // nolint: wrapcheck
+}
+
+func (l *lockFile) find(version, os, arch string) (int, bool) {
+	for ix, e := range l.Entries {
+		if e.Version == version && e.OS == os && e.Arch == arch {
+			return ix, true
+		}
+	}
+
+	return -1, false
+}
+
+// resolveFile locates the upstream File for version/os/arch in releases.
+func resolveFile(releases Releases, version, os, arch string) (File, bool) {
+	for _, release := range releases {
+		if release.Version != version {
+			continue
+		}
+
+		for _, file := range release.Downloads {
+			if file.OS == os && file.Architecture == arch {
+				return file, true
+			}
+		}
+	}
+
+	return File{}, false
+}
+
+// tagList collects repeated --tag flags.
+type tagList []string
+
+func (t *tagList) String() string { return strings.Join(*t, ",") }
+func (t *tagList) Set(v string) error {
+	*t = append(*t, v)
+
+	return nil
+}
+
+// runLockAdd implements `downloader add <version> --os <os> --arch <arch>
+// [--tag <tag>]...`: resolve the file from the upstream listing, pin its
+// SHA-256, and record it in downloader.lock.
+func runLockAdd(args []string) {
+	fs := flag.NewFlagSet("add", flag.ExitOnError)
+	osFlag := fs.String("os", "", "target OS")
+	archFlag := fs.String("arch", "", "target architecture")
+	sourceFlag := fs.String("source", "", "source backend to resolve the version from")
+	verifySignatures := fs.Bool("verify-signatures", false, "authenticate the resolved file against the distsign manifest before pinning it")
+	trustRoot := fs.String("trust-root", "", "path to a hex-encoded alternate distsign root public key, pinning trust instead of the compiled-in roots")
+
+	var tags tagList
+
+	fs.Var(&tags, "tag", "tag to attach to this entry (repeatable)")
+	_ = fs.Parse(args)
+
+	positional := fs.Args()
+	if len(positional) != 1 || *osFlag == "" || *archFlag == "" {
+		fmt.Fprintln(errOut, "usage: downloader add <version> --os <os> --arch <arch> [--tag <tag>]...")
+		os.Exit(-1)
+	}
+
+	version := positional[0]
+
+	src, err := sourceFromEnv(*sourceFlag)
+	if err != nil {
+		fmt.Fprintf(errOut, "error resolving source: %v\n", err)
+		os.Exit(-1)
+	}
+
+	releases, err := src.Releases()
+	if err != nil {
+		fmt.Fprintf(errOut, "error fetching releases: %v\n", err)
+		os.Exit(-1)
+	}
+
+	file, ok := resolveFile(releases, version, *osFlag, *archFlag)
+	if !ok {
+		fmt.Fprintf(errOut, "%s: no %s/%s file found\n", version, *osFlag, *archFlag)
+		os.Exit(-1)
+	}
+
+	if v := verifierFromFlags(src, *verifySignatures, *trustRoot); v != nil {
+		if err = v.checkManifest(version, file, time.Now()); err != nil {
+			fmt.Fprintf(errOut, "%s: %v\n", version, err)
+			os.Exit(-1)
+		}
+	}
+
+	lock, err := loadLockFile()
+	if err != nil {
+		fmt.Fprintf(errOut, "could not load %s: %v\n", lockFilename, err)
+		os.Exit(-1)
+	}
+
+	entry := lockEntry{
+		Version:  version,
+		Filename: file.Filename,
+		OS:       *osFlag,
+		Arch:     *archFlag,
+		SHA256:   string(file.SHA256Sum),
+		Size:     file.Size,
+		Tags:     []string(tags),
+	}
+
+	if ix, exists := lock.find(version, *osFlag, *archFlag); exists {
+		lock.Entries[ix] = entry
+	} else {
+		lock.Entries = append(lock.Entries, entry)
+	}
+
+	if err = lock.save(); err != nil {
+		fmt.Fprintf(errOut, "could not save %s: %v\n", lockFilename, err)
+		os.Exit(-1)
+	}
+
+	fmt.Fprintf(statusOut, "pinned %s %s/%s @ %s\n", version, *osFlag, *archFlag, file.SHA256Sum)
+}
+
+// runLockRemove implements `downloader remove <version> --os <os> --arch
+// <arch>`.
+func runLockRemove(args []string) {
+	fs := flag.NewFlagSet("remove", flag.ExitOnError)
+	osFlag := fs.String("os", "", "target OS")
+	archFlag := fs.String("arch", "", "target architecture")
+	_ = fs.Parse(args)
+
+	positional := fs.Args()
+	if len(positional) != 1 || *osFlag == "" || *archFlag == "" {
+		fmt.Fprintln(errOut, "usage: downloader remove <version> --os <os> --arch <arch>")
+		os.Exit(-1)
+	}
+
+	lock, err := loadLockFile()
+	if err != nil {
+		fmt.Fprintf(errOut, "could not load %s: %v\n", lockFilename, err)
+		os.Exit(-1)
+	}
+
+	ix, ok := lock.find(positional[0], *osFlag, *archFlag)
+	if !ok {
+		fmt.Fprintf(errOut, "%s %s/%s: not pinned\n", positional[0], *osFlag, *archFlag)
+		os.Exit(-1)
+	}
+
+	lock.Entries = append(lock.Entries[:ix], lock.Entries[ix+1:]...)
+
+	if err = lock.save(); err != nil {
+		fmt.Fprintf(errOut, "could not save %s: %v\n", lockFilename, err)
+		os.Exit(-1)
+	}
+
+	fmt.Fprintf(statusOut, "removed %s %s/%s\n", positional[0], *osFlag, *archFlag)
+}
+
+// runLockDownload implements `downloader download [--tag t] [--notag t]`:
+// fetch exactly the pinned entries, optionally filtered by tag, failing
+// loudly on any SHA-256 mismatch instead of silently recomputing it.
+func runLockDownload(args []string) {
+	fs := flag.NewFlagSet("download", flag.ExitOnError)
+	sourceFlag := fs.String("source", "", "source backend to fetch pinned files from")
+	installRoot := fs.String("install-root", "", "shared toolchain directory to link extracted versions into")
+	concurrency := fs.Int("concurrency", 1, "number of archives to download in parallel")
+	tag := fs.String("tag", "", "only download entries carrying this tag")
+	notag := fs.String("notag", "", "exclude entries carrying this tag")
+	verifySignatures := fs.Bool("verify-signatures", false, "authenticate the release list and each archive using the distsign two-key scheme")
+	trustRoot := fs.String("trust-root", "", "path to a hex-encoded alternate distsign root public key, pinning trust instead of the compiled-in roots")
+	_ = fs.Parse(args)
+
+	lock, err := loadLockFile()
+	if err != nil {
+		fmt.Fprintf(errOut, "could not load %s: %v\n", lockFilename, err)
+		os.Exit(-1)
+	}
+
+	src, err := sourceFromEnv(*sourceFlag)
+	if err != nil {
+		fmt.Fprintf(errOut, "error resolving source: %v\n", err)
+		os.Exit(-1)
+	}
+
+	v := verifierFromFlags(src, *verifySignatures, *trustRoot)
+
+	if !fetchLockEntries(src, v, lock.Entries, *tag, *notag, *installRoot, *concurrency) {
+		os.Exit(-1)
+	}
+}
+
+// fetchLockEntries downloads entries matching tag/notag (either may be
+// empty to skip that filter) and reports whether every matched entry is
+// now correctly on disk. Only the entry matching the running OS/arch is
+// queued for unpacking, since every entry shares the same per-version
+// install directory. A non-nil v additionally requires each not-yet-present
+// entry to match the signed distsign manifest before it's queued, and
+// authenticates its archive signature before it's renamed into place,
+// exactly as runDownload does outside lockfile mode.
+func fetchLockEntries(src Source, v *verifier, entries []lockEntry, tag, notag, installRoot string, concurrency int) bool {
+	var jobs, unpackJobs []job
+
+	ok := true
+
+	for _, e := range entries {
+		if tag != "" && !e.hasTag(tag) {
+			continue
+		}
+
+		if notag != "" && e.hasTag(notag) {
+			continue
+		}
+
+		file := e.file()
+
+		if !ensureDirectory(e.Version) {
+			ok = false
+
+			continue
+		}
+
+		blocked := false
+
+		switch present, mismatched := checkLockedHash(e.Version, file); {
+		case mismatched:
+			fmt.Fprintf(errOut, "%s/%s: on-disk file does not match pinned sha256 %s\n", e.Version, file.Filename, file.SHA256Sum)
+
+			ok = false
+			blocked = true
+		case present:
+			fmt.Fprintf(errOut, "already downloaded %s/%s\n", e.Version, file.Filename)
+		case v != nil && checkManifestFailed(v, e.Version, file):
+			ok = false
+			blocked = true
+		default:
+			jobs = append(jobs, job{e.Version, file})
+		}
+
+		// A file that failed its on-disk hash or manifest check was never
+		// queued for download, so it must not be queued for unpacking
+		// either: unpackRelease would clear the version's existing install
+		// directory and then try to extract an archive that was never
+		// fetched.
+		if blocked {
+			continue
+		}
+
+		if _, archOK := archiveKind(file); archOK && file.OS == runtime.GOOS && file.Architecture == runtime.GOARCH {
+			unpackJobs = append(unpackJobs, job{e.Version, file})
+		}
+	}
+
+	runDownloads(src, v, jobs, concurrency)
+
+	for _, j := range jobs {
+		if present, _ := checkLockedHash(j.release, j.file); !present {
+			fmt.Fprintf(errOut, "%s/%s: not downloaded with pinned sha256 %s\n", j.release, j.file.Filename, j.file.SHA256Sum)
+
+			ok = false
+		}
+	}
+
+	for _, j := range unpackJobs {
+		target := dlTarget(j.release, j.file)
+		if unpackRelease(j.release, target, j.file) {
+			linkInstallRoot(installRoot, j.release)
+		}
+	}
+
+	return ok
+}
+
+// checkManifestFailed reports whether file fails its distsign manifest
+// check, printing the error when it does.
+func checkManifestFailed(v *verifier, version string, file File) bool {
+	if err := v.checkManifest(version, file, time.Now()); err != nil {
+		fmt.Fprintf(errOut, "%s/%s: %v\n", version, file.Filename, err)
+
+		return true
+	}
+
+	return false
+}
+
+// checkLockedHash reports whether file is already correctly on disk under
+// dir. Unlike checkHash, a mismatch is reported explicitly rather than
+// folded into "not yet downloaded", since a lockfile pin makes a mismatch a
+// loud integrity failure rather than something to silently recompute past.
+func checkLockedHash(dir string, file File) (present, mismatched bool) {
+	target := dlTarget(dir, file)
+
+	info, err := os.Stat(target)
+	if err != nil || info == nil {
+		return false, false
+	}
+
+	digest := computeHash(target)
+	if digest == nil {
+		return false, false
+	}
+
+	if !file.SHA256Sum.Equal(digest) {
+		return false, true
+	}
+
+	return true, false
+}
+
+// runLockUpdate implements `downloader update [prefix]`: re-resolve every
+// pinned entry whose version starts with prefix (or every entry, if prefix
+// is empty) against the upstream listing and rewrite its SHA-256. This is a
+// literal string-prefix match, not a semver constraint (e.g. ">=1.22"); the
+// repo has no semver library, and a prefix covers the common "update all the
+// 1.22.x entries" case without one. Range and comparison operators are not
+// parsed: ">=1.22" is matched as a literal prefix and selects nothing.
+func runLockUpdate(args []string) {
+	fs := flag.NewFlagSet("update", flag.ExitOnError)
+	sourceFlag := fs.String("source", "", "source backend to resolve versions from")
+	verifySignatures := fs.Bool("verify-signatures", false, "authenticate a changed resolution against the distsign manifest before accepting its new sha256")
+	trustRoot := fs.String("trust-root", "", "path to a hex-encoded alternate distsign root public key, pinning trust instead of the compiled-in roots")
+	_ = fs.Parse(args)
+
+	var prefix string
+	if positional := fs.Args(); len(positional) > 0 {
+		prefix = positional[0]
+	}
+
+	lock, err := loadLockFile()
+	if err != nil {
+		fmt.Fprintf(errOut, "could not load %s: %v\n", lockFilename, err)
+		os.Exit(-1)
+	}
+
+	src, err := sourceFromEnv(*sourceFlag)
+	if err != nil {
+		fmt.Fprintf(errOut, "error resolving source: %v\n", err)
+		os.Exit(-1)
+	}
+
+	v := verifierFromFlags(src, *verifySignatures, *trustRoot)
+
+	releases, err := src.Releases()
+	if err != nil {
+		fmt.Fprintf(errOut, "error fetching releases: %v\n", err)
+		os.Exit(-1)
+	}
+
+	updated := 0
+
+	for ix, e := range lock.Entries {
+		if prefix != "" && !strings.HasPrefix(e.Version, prefix) {
+			continue
+		}
+
+		file, ok := resolveFile(releases, e.Version, e.OS, e.Arch)
+		if !ok {
+			fmt.Fprintf(errOut, "%s %s/%s: no longer available from source\n", e.Version, e.OS, e.Arch)
+
+			continue
+		}
+
+		if file.SHA256Sum == Hash(e.SHA256) {
+			continue
+		}
+
+		if v != nil {
+			if err = v.checkManifest(e.Version, file, time.Now()); err != nil {
+				fmt.Fprintf(errOut, "%s %s/%s: %v\n", e.Version, e.OS, e.Arch, err)
+
+				continue
+			}
+		}
+
+		fmt.Fprintf(statusOut, "%s %s/%s: sha256 changed %s -> %s\n", e.Version, e.OS, e.Arch, e.SHA256, file.SHA256Sum)
+
+		lock.Entries[ix].SHA256 = string(file.SHA256Sum)
+		lock.Entries[ix].Size = file.Size
+		updated++
+	}
+
+	if updated == 0 {
+		fmt.Fprintln(statusOut, "no pinned entries changed")
+
+		return
+	}
+
+	if err = lock.save(); err != nil {
+		fmt.Fprintf(errOut, "could not save %s: %v\n", lockFilename, err)
+		os.Exit(-1)
+	}
+
+	fmt.Fprintf(statusOut, "updated %d entries in %s\n", updated, lockFilename)
+}
@@ -1,20 +1,18 @@
 package main
 
 import (
-	"bytes"
 	"crypto"
 	"encoding/hex"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"net/http"
 	"os"
 	"path"
+	"runtime"
 	"strings"
 	"time"
-
-	"github.com/gocolly/colly"
 )
 
 const (
@@ -22,7 +20,6 @@ const (
 	dlURL              = "https://golang.org/dl/?mode=json&include=all"
 	fileDownloadFmt    = "https://golang.org/dl/%s"
 	bufferSize         = 1 << 20 // ~1MB
-	maxDownloadBody    = 1 << 29 // ~530MB
 	maxDownloadTimeout = 10 * time.Minute
 	requestTimeout     = 30 * time.Second
 	dirPerms           = 0o755
@@ -42,50 +39,180 @@ func main() {
 	statusOut = os.Stdout
 	errOut = os.Stderr
 
-	c := colly.NewCollector(colly.UserAgent(userAgent))
-	c.SetRequestTimeout(requestTimeout)
+	args := os.Args[1:]
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "verify":
+			runVerify(args[1:])
+
+			return
+		case "add":
+			runLockAdd(args[1:])
+
+			return
+		case "remove":
+			runLockRemove(args[1:])
+
+			return
+		case "update":
+			runLockUpdate(args[1:])
+
+			return
+		case "download":
+			runLockDownload(args[1:])
+
+			return
+		case "run":
+			runRun(args[1:])
 
-	c.OnResponse(func(r *colly.Response) {
-		releases, err := Parse(bytes.NewReader(r.Body))
-		if err != nil {
-			fmt.Fprintf(errOut, "error parsing releases: %v\n", err)
+			return
+		case "env":
+			runEnv(args[1:])
+
+			return
+		case "which":
+			runWhich(args[1:])
 
 			return
 		}
+	}
+
+	runDownload(args)
+}
+
+// runDownload is the default, no-subcommand entry point: a full crawl of the
+// upstream listing when no downloader.lock is present, or a pinned download
+// of every locked entry when one is.
+func runDownload(args []string) {
+	if lockFileExists() {
+		runLockDownload(args)
+
+		return
+	}
+
+	fs := flag.NewFlagSet("download", flag.ExitOnError)
+	installRoot := fs.String("install-root", "", "shared toolchain directory to link extracted versions into")
+	concurrency := fs.Int("concurrency", 1, "number of archives to download in parallel")
+	sourceFlag := fs.String("source", "", "source backend (https://, file://, mirror://); defaults to golang.org/dl, overridable via "+sourceEnvVar)
+	verifySignatures := fs.Bool("verify-signatures", false, "authenticate the release list and each archive using the distsign two-key scheme")
+	trustRoot := fs.String("trust-root", "", "path to a hex-encoded alternate distsign root public key, pinning trust instead of the compiled-in roots")
+	_ = fs.Parse(args)
+
+	src, err := sourceFromEnv(*sourceFlag)
+	if err != nil {
+		fmt.Fprintf(errOut, "error resolving source: %v\n", err)
+		os.Exit(-1)
+	}
+
+	v := verifierFromFlags(src, *verifySignatures, *trustRoot)
+
+	releases, err := src.Releases()
+	if err != nil {
+		fmt.Fprintf(errOut, "error fetching releases: %v\n", err)
+		os.Exit(-1)
+	}
+
+	var jobs, unpackJobs []job
+
+	for _, release := range releases {
+		releaseName := release.Version
+		if skipRelease(releaseName) {
+			fmt.Fprintf(errOut, "skipping %s\n", releaseName)
+
+			continue
+		}
 
-		for _, release := range releases {
-			releaseName := release.Version
-			if skipRelease(releaseName) {
-				fmt.Fprintf(errOut, "skipping %s\n", releaseName)
+		if !ensureDirectory(releaseName) {
+			continue
+		}
+
+		for _, download := range release.Downloads {
+			if skipFile(download) {
+				fmt.Fprintf(errOut, "skipping %s/%s\n", releaseName, download.Filename)
 
 				continue
 			}
 
-			if !ensureDirectory(releaseName) {
-				continue
+			if checkHash(releaseName, download) {
+				fmt.Fprintf(errOut, "already downloaded %s/%s\n", releaseName, download.Filename)
+			} else if v == nil {
+				jobs = append(jobs, job{releaseName, download})
+			} else if err = v.checkManifest(releaseName, download, time.Now()); err != nil {
+				fmt.Fprintf(errOut, "skipping %s/%s: %v\n", releaseName, download.Filename, err)
+			} else {
+				jobs = append(jobs, job{releaseName, download})
+			}
+
+			if _, ok := archiveKind(download); ok && download.OS == runtime.GOOS && download.Architecture == runtime.GOARCH {
+				unpackJobs = append(unpackJobs, job{releaseName, download})
 			}
+		}
+	}
+
+	runDownloads(src, v, jobs, *concurrency)
+
+	for _, j := range unpackJobs {
+		target := dlTarget(j.release, j.file)
+		if unpackRelease(j.release, target, j.file) {
+			linkInstallRoot(*installRoot, j.release)
+		}
+	}
+}
+
+// verifierFromFlags builds a verifier from --verify-signatures/--trust-root
+// when verifySignatures is set, or returns nil when it isn't. Shared by
+// every subcommand that accepts those two flags, so opting into distsign
+// verification works the same way everywhere.
+func verifierFromFlags(src Source, verifySignatures bool, trustRoot string) *verifier {
+	if !verifySignatures {
+		return nil
+	}
 
-			for _, download := range release.Downloads {
-				if skipFile(download) {
-					fmt.Fprintf(errOut, "skipping %s/%s\n", releaseName, download.Filename)
+	v, err := newVerifier(src, trustRoot)
+	if err != nil {
+		fmt.Fprintf(errOut, "error initializing verifier: %v\n", err)
+		os.Exit(-1)
+	}
 
-					continue
-				}
+	return v
+}
 
-				if checkHash(releaseName, download) {
-					fmt.Fprintf(errOut, "already downloaded %s/%s\n", releaseName, download.Filename)
+// runVerify implements `downloader verify <dir>...`, re-running the
+// manifest and per-file signature checks against archives already on disk.
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	sourceFlag := fs.String("source", "", "source backend to fetch signing keys, manifests and .sig files from")
+	trustRoot := fs.String("trust-root", "", "path to a hex-encoded alternate distsign root public key, pinning trust instead of the compiled-in roots")
+	_ = fs.Parse(args)
+
+	dirs := fs.Args()
+	if len(dirs) == 0 {
+		fmt.Fprintln(errOut, "usage: downloader verify <dir> [<dir>...]")
+		os.Exit(-1)
+	}
 
-					continue
-				}
+	src, err := sourceFromEnv(*sourceFlag)
+	if err != nil {
+		fmt.Fprintf(errOut, "error resolving source: %v\n", err)
+		os.Exit(-1)
+	}
 
-				fmt.Fprintf(statusOut, "downloading %s/%s [%s]\n", releaseName, download.Filename, download.SHA256Sum)
-				downloadFile(c, releaseName, download)
-			}
+	v, err := newVerifier(src, *trustRoot)
+	if err != nil {
+		fmt.Fprintf(errOut, "error initializing verifier: %v\n", err)
+		os.Exit(-1)
+	}
+
+	ok := true
+
+	for _, dir := range dirs {
+		if !verifyDir(v, dir) {
+			ok = false
 		}
-	})
+	}
 
-	if err := c.Visit(dlURL); err != nil {
-		fmt.Fprintf(errOut, "error visiting %s: %v\n", dlURL, err)
+	if !ok {
 		os.Exit(-1)
 	}
 }
@@ -224,40 +351,6 @@ func checkHash(dir string, file File) bool {
 	return matches
 }
 
-func downloadFile(c *colly.Collector, releaseName string, download File) {
-	d := c.Clone()
-	d.MaxBodySize = maxDownloadBody
-	d.SetRequestTimeout(maxDownloadTimeout)
-
-	d.OnResponse(func(dr *colly.Response) {
-		if dr.StatusCode == http.StatusOK {
-			target := dlTarget(releaseName, download)
-			if err := dr.Save(target); err != nil {
-				fmt.Fprintf(errOut, "  [%d] could not save %q: %v\n", d.ID, target, err)
-
-				return
-			}
-
-			fmt.Fprintf(statusOut, "  [%d] downloaded %q\n", d.ID, target)
-
-			hashFile := dlSHA(target)
-
-			if err := writeHash(hashFile, string(download.SHA256Sum)); err != nil {
-				return
-			}
-
-			fmt.Fprintf(statusOut, "  [%d] saved hash %q\n", d.ID, hashFile)
-		}
-	})
-
-	dl := fmt.Sprintf(fileDownloadFmt, download.Filename)
-	fmt.Fprintf(errOut, "  [%d] getting %s\n", d.ID, dl)
-
-	if err := d.Visit(dl); err != nil {
-		fmt.Fprintf(errOut, "  [%d] could not visit %q: %v\n", d.ID, dl, err)
-	}
-}
-
 func writeHash(hashFile, hash string) error {
 	if err := ioutil.WriteFile(hashFile, []byte(hash), filePerms); err != nil {
 		fmt.Fprintf(errOut, "could not write hash to %q: %v\n", hashFile, err)
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const progressInterval = 500 * time.Millisecond
+
+// progress tracks bytes written for one in-flight download and renders a
+// periodic bytes/sec, ETA, and percent-complete line to statusOut.
+type progress struct {
+	worker     int
+	label      string
+	total      uint64
+	resumeFrom int64
+	written    int64
+	start      time.Time
+	last       time.Time
+}
+
+func newProgress(worker int, releaseName string, file File, resumeFrom int64) *progress {
+	now := time.Now()
+
+	return &progress{
+		worker:     worker,
+		label:      fmt.Sprintf("%s/%s", releaseName, file.Filename),
+		total:      file.Size,
+		resumeFrom: resumeFrom,
+		written:    resumeFrom,
+		start:      now,
+		last:       now,
+	}
+}
+
+func (p *progress) Write(b []byte) (int, error) {
+	p.written += int64(len(b))
+
+	if time.Since(p.last) >= progressInterval {
+		p.render()
+		p.last = time.Now()
+	}
+
+	return len(b), nil
+}
+
+func (p *progress) render() {
+	elapsed := time.Since(p.start).Seconds()
+	if elapsed <= 0 {
+		elapsed = 1
+	}
+
+	// Rate is this session's bytes over this session's elapsed time: bytes
+	// already on disk from a resumed download were written in a previous
+	// run, and crediting them to this run's elapsed time would report an
+	// absurd rate and near-zero ETA right after resuming a mostly-complete
+	// file.
+	rate := float64(p.written-p.resumeFrom) / elapsed
+
+	var pct float64
+	if p.total > 0 {
+		pct = float64(p.written) / float64(p.total) * 100
+	}
+
+	var eta time.Duration
+	if rate > 0 && p.total > 0 {
+		if remaining := float64(p.total) - float64(p.written); remaining > 0 {
+			eta = time.Duration(remaining/rate) * time.Second
+		}
+	}
+
+	fmt.Fprintf(statusOut, "  [%d] %s %.1f%% %.0f B/s eta %s\n", p.worker, p.label, pct, rate, eta)
+}
+
+func (p *progress) done() {
+	p.render()
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+func goBinaryName() string {
+	if runtime.GOOS == "windows" {
+		return "go.exe"
+	}
+
+	return "go"
+}
+
+func goBinaryPath(root string) string { return filepath.Join(root, "bin", goBinaryName()) }
+
+// versionRoot is the absolute path to version's extracted GOROOT.
+func versionRoot(version string) string {
+	root, err := filepath.Abs(installDir(version))
+	if err != nil {
+		return installDir(version)
+	}
+
+	return root
+}
+
+// ensureVersion downloads and extracts version for the running OS/arch if
+// its GOROOT layout isn't already present, reusing the same resolve,
+// download and extraction path as the default download flow.
+func ensureVersion(src Source, version string) bool {
+	releases, err := src.Releases()
+	if err != nil {
+		fmt.Fprintf(errOut, "error fetching releases: %v\n", err)
+
+		return false
+	}
+
+	file, ok := resolveFile(releases, version, runtime.GOOS, runtime.GOARCH)
+	if !ok {
+		fmt.Fprintf(errOut, "%s: no %s/%s file found\n", version, runtime.GOOS, runtime.GOARCH)
+
+		return false
+	}
+
+	if _, ok = archiveKind(file); !ok {
+		fmt.Fprintf(errOut, "%s: %s is not an archive\n", version, file.Filename)
+
+		return false
+	}
+
+	if !ensureDirectory(version) {
+		return false
+	}
+
+	if !checkHash(version, file) {
+		runDownloads(src, nil, []job{{version, file}}, 1)
+
+		if !checkHash(version, file) {
+			fmt.Fprintf(errOut, "%s: download failed\n", version)
+
+			return false
+		}
+	}
+
+	return unpackRelease(version, dlTarget(version, file), file)
+}
+
+// toolchainEnv is the environment for running a go binary out of root: its
+// GOROOT set, and its bin/ prepended to PATH with any duplicate occurrence
+// of that same bin/ removed.
+func toolchainEnv(root string) []string {
+	bin := filepath.Join(root, "bin")
+
+	env := os.Environ()
+	out := make([]string, 0, len(env)+2)
+
+	out = append(out, "GOROOT="+root)
+
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "GOROOT=") || strings.HasPrefix(kv, "PATH=") {
+			continue
+		}
+
+		out = append(out, kv)
+	}
+
+	out = append(out, "PATH="+dedupPath(bin, os.Getenv("PATH")))
+
+	return out
+}
+
+// dedupPath prepends prefix to path, dropping any later occurrence of
+// prefix so the same directory doesn't appear twice.
+func dedupPath(prefix, path string) string {
+	out := []string{prefix}
+
+	for _, p := range filepath.SplitList(path) {
+		if p == prefix {
+			continue
+		}
+
+		out = append(out, p)
+	}
+
+	return strings.Join(out, string(os.PathListSeparator))
+}
+
+func sourceForVersionCommand(fs *flag.FlagSet) *string {
+	return fs.String("source", "", "source backend to resolve and fetch the version from")
+}
+
+// runRun implements `downloader run <version> [-- <args...>]`, the
+// golang.org/dl/go1.N shim behavior: ensure the version is installed, then
+// exec its bin/go with GOROOT and PATH set, forwarding stdio and exit code.
+func runRun(args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	sourceFlag := sourceForVersionCommand(fs)
+	_ = fs.Parse(args)
+
+	positional := fs.Args()
+	if len(positional) == 0 {
+		fmt.Fprintln(errOut, "usage: downloader run <version> [-- <args...>]")
+		os.Exit(-1)
+	}
+
+	version, toolArgs := positional[0], positional[1:]
+
+	// Flag parsing stops at <version>, so the "--" separator is still here.
+	if len(toolArgs) > 0 && toolArgs[0] == "--" {
+		toolArgs = toolArgs[1:]
+	}
+
+	src, err := sourceFromEnv(*sourceFlag)
+	if err != nil {
+		fmt.Fprintf(errOut, "error resolving source: %v\n", err)
+		os.Exit(-1)
+	}
+
+	if !ensureVersion(src, version) {
+		os.Exit(-1)
+	}
+
+	root := versionRoot(version)
+	goBin := goBinaryPath(root)
+
+	cmd := exec.Command(goBin, toolArgs...)
+	cmd.Env = toolchainEnv(root)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err = cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.ExitCode())
+		}
+
+		fmt.Fprintf(errOut, "could not run %q: %v\n", goBin, err)
+		os.Exit(-1)
+	}
+}
+
+// runEnv implements `downloader env <version>`, printing the shell exports
+// that runRun applies internally.
+func runEnv(args []string) {
+	fs := flag.NewFlagSet("env", flag.ExitOnError)
+	sourceFlag := sourceForVersionCommand(fs)
+	_ = fs.Parse(args)
+
+	positional := fs.Args()
+	if len(positional) != 1 {
+		fmt.Fprintln(errOut, "usage: downloader env <version>")
+		os.Exit(-1)
+	}
+
+	version := positional[0]
+
+	src, err := sourceFromEnv(*sourceFlag)
+	if err != nil {
+		fmt.Fprintf(errOut, "error resolving source: %v\n", err)
+		os.Exit(-1)
+	}
+
+	if !ensureVersion(src, version) {
+		os.Exit(-1)
+	}
+
+	root := versionRoot(version)
+
+	fmt.Fprintf(statusOut, "export GOROOT=%s\n", root)
+	fmt.Fprintf(statusOut, "export PATH=%s\n", dedupPath(filepath.Join(root, "bin"), os.Getenv("PATH")))
+}
+
+// runWhich implements `downloader which <version>`, printing the resolved
+// go binary path.
+func runWhich(args []string) {
+	fs := flag.NewFlagSet("which", flag.ExitOnError)
+	sourceFlag := sourceForVersionCommand(fs)
+	_ = fs.Parse(args)
+
+	positional := fs.Args()
+	if len(positional) != 1 {
+		fmt.Fprintln(errOut, "usage: downloader which <version>")
+		os.Exit(-1)
+	}
+
+	version := positional[0]
+
+	src, err := sourceFromEnv(*sourceFlag)
+	if err != nil {
+		fmt.Fprintf(errOut, "error resolving source: %v\n", err)
+		os.Exit(-1)
+	}
+
+	if !ensureVersion(src, version) {
+		os.Exit(-1)
+	}
+
+	fmt.Fprintln(statusOut, goBinaryPath(versionRoot(version)))
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	sourceEnvVar = "DOWNLOADER_SOURCE"
+
+	schemeFile   = "file"
+	schemeMirror = "mirror"
+)
+
+// Source abstracts where release listings and archive bytes come from, so
+// the downloader can target golang.org/dl, a local prefetch tree, or a
+// corporate mirror interchangeably.
+//
+// There is no Go module proxy backend: ConvertURL rejects a gomodproxy://
+// source as an unsupported scheme.
+type Source interface {
+	// Releases fetches and parses the release listing.
+	Releases() (Releases, error)
+	// Open returns a reader for file, honoring offset as a resume point when
+	// the backend supports it; resumed reports whether offset was honored,
+	// so the caller knows whether to restart its hash from scratch.
+	Open(file File, offset int64) (rc io.ReadCloser, resumed bool, err error)
+	// OpenArtifact returns a reader for a distsign side artifact (keys.json,
+	// manifest.json, or a "<file>.sig") associated with releaseName, or with
+	// no release ("") for artifacts that apply across all releases.
+	OpenArtifact(releaseName, name string) (io.ReadCloser, error)
+}
+
+// LooksLikeURL reports whether raw has an explicit scheme, as opposed to
+// being empty or a bare path meant for the default backend.
+func LooksLikeURL(raw string) bool {
+	u, err := url.Parse(raw)
+
+	return err == nil && u.Scheme != ""
+}
+
+// ConvertURL resolves raw (a --source flag or DOWNLOADER_SOURCE value) into
+// a Source, defaulting to the upstream golang.org/dl backend when raw is
+// empty.
+func ConvertURL(raw string) (Source, error) {
+	if raw == "" {
+		return &httpsSource{listURL: dlURL, fileURLFmt: fileDownloadFmt}, nil
+	}
+
+	if !LooksLikeURL(raw) {
+		return nil, fmt.Errorf("source %q has no scheme", raw)
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse source %q: %w", raw, err)
+	}
+
+	switch u.Scheme {
+	case "https", "http":
+		return &httpsSource{listURL: raw, fileURLFmt: strings.TrimSuffix(raw, "/") + "/%s"}, nil
+	case schemeFile:
+		return &fileSource{root: filepath.FromSlash(u.Path)}, nil
+	case schemeMirror:
+		return &mirrorSource{host: u.Host, base: u.Path}, nil
+	default:
+		return nil, fmt.Errorf("unsupported source scheme %q", u.Scheme)
+	}
+}
+
+// sourceFromEnv resolves --source, falling back to the DOWNLOADER_SOURCE
+// environment variable and then the upstream default.
+func sourceFromEnv(flagValue string) (Source, error) {
+	if flagValue != "" {
+		return ConvertURL(flagValue)
+	}
+
+	return ConvertURL(os.Getenv(sourceEnvVar))
+}
+
+// httpsSource is the default backend: golang.org/dl or anything that serves
+// the same JSON listing and file layout over HTTP(S).
+type httpsSource struct {
+	listURL    string
+	fileURLFmt string
+}
+
+func (s *httpsSource) Releases() (Releases, error) {
+	req, err := http.NewRequest(http.MethodGet, s.listURL, nil)
+	if err != nil {
+		return nil, err // nolint: wrapcheck
+	}
+
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := (&http.Client{Timeout: requestTimeout}).Do(req)
+	if err != nil {
+		return nil, err // nolint: wrapcheck
+	}
+	defer resp.Body.Close()
+
+	return Parse(resp.Body)
+}
+
+func (s *httpsSource) Open(file File, offset int64) (io.ReadCloser, bool, error) {
+	dl := fmt.Sprintf(s.fileURLFmt, file.Filename)
+
+	req, err := http.NewRequest(http.MethodGet, dl, nil)
+	if err != nil {
+		return nil, false, err // nolint: wrapcheck
+	}
+
+	req.Header.Set("User-Agent", userAgent)
+
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := (&http.Client{Timeout: maxDownloadTimeout}).Do(req)
+	if err != nil {
+		return nil, false, err // nolint: wrapcheck
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, true, nil
+	case http.StatusOK:
+		return resp.Body, false, nil
+	default:
+		resp.Body.Close()
+
+		return nil, false, fmt.Errorf("unexpected status %d for %q", resp.StatusCode, dl)
+	}
+}
+
+func (s *httpsSource) OpenArtifact(releaseName, name string) (io.ReadCloser, error) {
+	base := strings.TrimSuffix(s.fileURLFmt, "%s")
+
+	u := base + "distsign/"
+	if releaseName != "" {
+		u += releaseName + "/"
+	}
+
+	u += name
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err // nolint: wrapcheck
+	}
+
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := (&http.Client{Timeout: requestTimeout}).Do(req)
+	if err != nil {
+		return nil, err // nolint: wrapcheck
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+
+		return nil, fmt.Errorf("unexpected status %d for %q", resp.StatusCode, u)
+	}
+
+	return resp.Body, nil
+}
+
+// fileSource serves releases from a local directory tree of prefetched
+// archives, one subdirectory per version, each file paired with a sibling
+// "<file>.sha256". It exists for air-gapped installs that have prestaged
+// tarballs instead of reaching out to a network mirror.
+type fileSource struct {
+	root string
+}
+
+func (s *fileSource) Releases() (Releases, error) {
+	entries, err := ioutil.ReadDir(s.root)
+	if err != nil {
+		return nil, err // nolint: wrapcheck
+	}
+
+	var releases Releases
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		files, err := s.releaseFiles(entry.Name())
+		if err != nil {
+			fmt.Fprintf(errOut, "could not read %q: %v\n", entry.Name(), err)
+
+			continue
+		}
+
+		releases = append(releases, Release{Version: entry.Name(), IsStable: true, Downloads: files})
+	}
+
+	return releases, nil
+}
+
+func (s *fileSource) releaseFiles(version string) ([]File, error) {
+	dir := filepath.Join(s.root, version)
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err // nolint: wrapcheck
+	}
+
+	var files []File
+
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".sha256") {
+			continue
+		}
+
+		sum, err := ioutil.ReadFile(filepath.Join(dir, entry.Name()+".sha256"))
+		if err != nil {
+			fmt.Fprintf(errOut, "could not read sha256 for %q: %v\n", entry.Name(), err)
+
+			continue
+		}
+
+		osName, arch, kind := parseFilenamePlatform(version, entry.Name())
+
+		files = append(files, File{
+			Filename:     entry.Name(),
+			OS:           osName,
+			Architecture: arch,
+			Version:      version,
+			SHA256Sum:    Hash(strings.TrimSpace(string(sum))),
+			Size:         uint64(entry.Size()),
+			Kind:         kind,
+		})
+	}
+
+	return files, nil
+}
+
+// parseFilenamePlatform infers the OS, architecture and Kind a golang.org/dl
+// JSON listing would have carried for filename, following upstream's own
+// "<version>.<os>-<arch>.<ext>" (or "<version>.src.tar.gz") naming
+// convention. A file:// tree has no JSON listing of its own to read this
+// metadata from, so it's recovered from the filename instead.
+func parseFilenamePlatform(version, filename string) (osName, arch, kind string) {
+	rest := strings.TrimPrefix(filename, version+".")
+	if rest == filename {
+		return "", "", ""
+	}
+
+	platform := rest
+	if ix := strings.Index(rest, "."); ix >= 0 {
+		platform = rest[:ix]
+	}
+
+	if platform == "src" {
+		return "", "", "source"
+	}
+
+	osArch := strings.SplitN(platform, "-", 2)
+	if len(osArch) != 2 {
+		return "", "", ""
+	}
+
+	kind = "archive"
+	if strings.HasSuffix(filename, ".msi") || strings.HasSuffix(filename, ".pkg") {
+		kind = "installer"
+	}
+
+	return osArch[0], osArch[1], kind
+}
+
+func (s *fileSource) Open(file File, offset int64) (io.ReadCloser, bool, error) {
+	in, err := os.Open(filepath.Join(s.root, file.Version, file.Filename))
+	if err != nil {
+		return nil, false, err // nolint: wrapcheck
+	}
+
+	if offset > 0 {
+		if _, err = in.Seek(offset, io.SeekStart); err != nil {
+			in.Close()
+
+			return nil, false, err // nolint: wrapcheck
+		}
+	}
+
+	return in, true, nil
+}
+
+func (s *fileSource) OpenArtifact(releaseName, name string) (io.ReadCloser, error) {
+	dir := s.root
+	if releaseName != "" {
+		dir = filepath.Join(dir, releaseName)
+	}
+
+	return os.Open(filepath.Join(dir, name)) // nolint: wrapcheck
+}
+
+// mirrorSource points at a corporate mirror serving the same JSON listing
+// and file layout as golang.org/dl under a different host and path prefix,
+// e.g. mirror://dl.internal.example.com/go.
+type mirrorSource struct {
+	host string
+	base string
+}
+
+func (s *mirrorSource) listURL() string {
+	return fmt.Sprintf("https://%s%s/?mode=json&include=all", s.host, s.base)
+}
+
+func (s *mirrorSource) fileURLFmt() string {
+	return fmt.Sprintf("https://%s%s/%%s", s.host, s.base)
+}
+
+func (s *mirrorSource) Releases() (Releases, error) {
+	return (&httpsSource{listURL: s.listURL()}).Releases()
+}
+
+func (s *mirrorSource) Open(file File, offset int64) (io.ReadCloser, bool, error) {
+	return (&httpsSource{fileURLFmt: s.fileURLFmt()}).Open(file, offset)
+}
+
+func (s *mirrorSource) OpenArtifact(releaseName, name string) (io.ReadCloser, error) {
+	return (&httpsSource{fileURLFmt: s.fileURLFmt()}).OpenArtifact(releaseName, name)
+}